@@ -0,0 +1,186 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+CacheClient là giao diện pluggable cho nơi lưu trữ rendered page cache. InMemoryCache
+cài đặt nó cho trường hợp chạy một instance; một backend Redis có thể cài đặt cùng
+interface này để chia sẻ cache giữa nhiều instance mà không đụng tới renderTemplate.
+*/
+type CacheClient interface {
+	// Get trả về dữ liệu đã render, Content-Type của nó, và true nếu key còn tồn tại
+	// và chưa hết hạn.
+	Get(key string) (data []byte, contentType string, ok bool)
+
+	// Set lưu data dưới key, gắn với contentType, hết hạn sau expiration (0 nghĩa là
+	// không bao giờ tự hết hạn), và được gắn nhãn bằng tags để Flush theo nhóm.
+	Set(key string, data []byte, contentType string, expiration time.Duration, tags ...string)
+
+	// Flush xóa mọi entry thuộc group, hoặc nếu tags được chỉ định, chỉ xóa những entry
+	// mang ít nhất một trong các tags đó (trong phạm vi group).
+	Flush(group string, tags ...string)
+}
+
+/*
+cacheMetrics là hook tùy chọn để đo tỉ lệ hit/miss của page cache. application có thể
+để nil (không đo gì) hoặc gắn một implementation xuất ra Prometheus/StatsD.
+*/
+type cacheMetrics interface {
+	Hit(key string)
+	Miss(key string)
+}
+
+/*
+CachedPage là tham số mà handler truyền vào renderTemplate để opt-in vào page cache.
+Key định danh page trong phạm vi Group (ví dụ Group "product", Key "show:123"), từ đó
+renderTemplate ghép thêm URL và user vào để ra cache key thật sự. Expiration là thời
+gian sống; Tags cho phép Flush cả một lô entry liên quan khi dữ liệu nguồn thay đổi
+(ví dụ mọi page "product" khi một sản phẩm được sửa).
+*/
+type CachedPage struct {
+	Key        string
+	Group      string
+	Expiration time.Duration
+	Tags       []string
+}
+
+/*
+inMemoryCacheEntry lưu payload đã render cùng metadata cần để Flush theo tag và hết
+hạn theo thời gian.
+*/
+type inMemoryCacheEntry struct {
+	data        []byte
+	contentType string
+	tags        []string
+	expiresAt   time.Time
+}
+
+/*
+InMemoryCache là CacheClient mặc định dùng cho một instance duy nhất: một map bảo vệ
+bởi mutex, cộng với chỉ mục tag->key->{} (field groups) để Flush theo tag không phải
+quét toàn bộ cache; Flush theo group mà không có tag thì quét mọi entry. Đây không phải LRU có giới hạn kích thước - entry chỉ rời khỏi cache khi
+hết hạn hoặc bị Flush tường minh; một backend LRU có giới hạn bộ nhớ thật sự nên cài
+đặt CacheClient riêng nếu cần.
+*/
+type InMemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]inMemoryCacheEntry
+	groups  map[string]map[string]struct{}
+}
+
+/*
+NewInMemoryCache khởi tạo một InMemoryCache rỗng, sẵn sàng sử dụng.
+*/
+func NewInMemoryCache() *InMemoryCache {
+	return &InMemoryCache{
+		entries: make(map[string]inMemoryCacheEntry),
+		groups:  make(map[string]map[string]struct{}),
+	}
+}
+
+/*
+groupKey ghép group và key thành một khóa duy nhất trong c.entries, theo cùng quy ước
+namespacing mà TemplateRenderer dùng cho template cache.
+*/
+func groupKey(group, key string) string {
+	return group + ":" + key
+}
+
+// Get cài đặt CacheClient.Get.
+func (c *InMemoryCache) Get(key string) ([]byte, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, "", false
+	}
+
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, "", false
+	}
+
+	return entry.data, entry.contentType, true
+}
+
+// Set cài đặt CacheClient.Set.
+func (c *InMemoryCache) Set(key string, data []byte, contentType string, expiration time.Duration, tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if expiration > 0 {
+		expiresAt = time.Now().Add(expiration)
+	}
+
+	c.entries[key] = inMemoryCacheEntry{
+		data:        data,
+		contentType: contentType,
+		tags:        tags,
+		expiresAt:   expiresAt,
+	}
+
+	for _, tag := range tags {
+		tagged, ok := c.groups[tag]
+		if !ok {
+			tagged = make(map[string]struct{})
+			c.groups[tag] = tagged
+		}
+		tagged[key] = struct{}{}
+	}
+}
+
+// Flush cài đặt CacheClient.Flush.
+func (c *InMemoryCache) Flush(group string, tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := group + ":"
+	inGroup := func(key string) bool {
+		return len(key) >= len(prefix) && key[:len(prefix)] == prefix
+	}
+
+	if len(tags) == 0 {
+		for key := range c.entries {
+			if inGroup(key) {
+				delete(c.entries, key)
+			}
+		}
+		return
+	}
+
+	// Xóa theo tag nhưng chỉ trong phạm vi group: một tag có thể được gắn lên entry của
+	// nhiều group khác nhau (ví dụ "product" dùng chung cho cả group "admin" lẫn
+	// "members"), nên không được xóa sạch mọi key mang tag đó bất kể group.
+	for _, tag := range tags {
+		tagged, ok := c.groups[tag]
+		if !ok {
+			continue
+		}
+		for key := range tagged {
+			if !inGroup(key) {
+				continue
+			}
+			delete(tagged, key)
+			// Chỉ mục có thể còn giữ key cũ (entry đã bị Flush theo group, hết hạn, hoặc
+			// được Set lại với tags khác), nên chỉ xóa khi entry hiện tại còn mang tag.
+			entry, ok := c.entries[key]
+			if !ok {
+				continue
+			}
+			for _, t := range entry.tags {
+				if t == tag {
+					delete(c.entries, key)
+					break
+				}
+			}
+		}
+		if len(tagged) == 0 {
+			delete(c.groups, tag)
+		}
+	}
+}
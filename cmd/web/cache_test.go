@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInMemoryCacheSetGet(t *testing.T) {
+	c := NewInMemoryCache()
+
+	key := groupKey("product", "show:1")
+	c.Set(key, []byte("<html>hi</html>"), "text/html; charset=utf-8", 0)
+
+	data, contentType, ok := c.Get(key)
+	if !ok {
+		t.Fatalf("Get(%q): expected entry to be found", key)
+	}
+	if string(data) != "<html>hi</html>" {
+		t.Errorf("Get(%q): data = %q, want %q", key, data, "<html>hi</html>")
+	}
+	if contentType != "text/html; charset=utf-8" {
+		t.Errorf("Get(%q): contentType = %q, want %q", key, contentType, "text/html; charset=utf-8")
+	}
+
+	if _, _, ok := c.Get(groupKey("product", "show:2")); ok {
+		t.Errorf("Get on a never-set key: expected ok=false")
+	}
+}
+
+func TestInMemoryCacheExpiry(t *testing.T) {
+	c := NewInMemoryCache()
+
+	key := groupKey("product", "show:1")
+	c.Set(key, []byte("stale soon"), "text/plain", 10*time.Millisecond)
+
+	if _, _, ok := c.Get(key); !ok {
+		t.Fatalf("Get(%q) before expiration: expected ok=true", key)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, _, ok := c.Get(key); ok {
+		t.Errorf("Get(%q) after expiration: expected ok=false", key)
+	}
+}
+
+func TestInMemoryCacheFlushGroupOnly(t *testing.T) {
+	c := NewInMemoryCache()
+
+	admin := groupKey("admin", "page:1")
+	members := groupKey("members", "page:1")
+	c.Set(admin, []byte("admin page"), "text/html", 0)
+	c.Set(members, []byte("members page"), "text/html", 0)
+
+	c.Flush("admin")
+
+	if _, _, ok := c.Get(admin); ok {
+		t.Errorf("Get(%q) after Flush(%q): expected entry to be gone", admin, "admin")
+	}
+	if _, _, ok := c.Get(members); !ok {
+		t.Errorf("Get(%q) after Flush(%q): expected entry to survive", members, "admin")
+	}
+}
+
+// TestInMemoryCacheFlushTagScopedToGroup tái hiện bug đã sửa ở Flush: Flush(group, tag)
+// chỉ được xóa những entry vừa thuộc group vừa mang tag đó, không được xóa mọi entry ở
+// group khác chỉ vì chúng mang cùng tag.
+func TestInMemoryCacheFlushTagScopedToGroup(t *testing.T) {
+	c := NewInMemoryCache()
+
+	admin := groupKey("admin", "page:1")
+	members := groupKey("members", "page:1")
+	c.Set(admin, []byte("admin page"), "text/html", 0, "product")
+	c.Set(members, []byte("members page"), "text/html", 0, "product")
+
+	c.Flush("admin", "product")
+
+	if _, _, ok := c.Get(admin); ok {
+		t.Errorf("Get(%q) after Flush(%q, %q): expected entry to be gone", admin, "admin", "product")
+	}
+	if _, _, ok := c.Get(members); !ok {
+		t.Errorf("Get(%q) after Flush(%q, %q): expected entry in a different group to survive", members, "admin", "product")
+	}
+}
+
+func TestInMemoryCacheFlushTagLeavesOtherTagsInGroupAlone(t *testing.T) {
+	c := NewInMemoryCache()
+
+	productPage := groupKey("admin", "product:1")
+	orderPage := groupKey("admin", "order:1")
+	c.Set(productPage, []byte("product"), "text/html", 0, "product")
+	c.Set(orderPage, []byte("order"), "text/html", 0, "order")
+
+	c.Flush("admin", "product")
+
+	if _, _, ok := c.Get(productPage); ok {
+		t.Errorf("Get(%q) after Flush(%q, %q): expected entry to be gone", productPage, "admin", "product")
+	}
+	if _, _, ok := c.Get(orderPage); !ok {
+		t.Errorf("Get(%q) after Flush(%q, %q): expected untagged-for-this-flush entry to survive", orderPage, "admin", "product")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+/*
+isHXRequest báo cho renderTemplate biết request hiện tại có phải một request HTMX hay
+không, dựa trên header "HX-Request" mà thư viện client htmx gắn vào mọi request nó gửi.
+*/
+func isHXRequest(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+/*
+isHXBoosted báo cho renderTemplate biết request hiện tại có đến từ một phần tử được
+hx-boost hay không. htmx gắn cả "HX-Request" lẫn "HX-Boosted" cho request boosted.
+renderTemplate coi cả hai như nhau (render fragment khi một trong hai cờ được set); hàm
+này tách riêng để chỗ gọi khác có thể phân biệt hai trường hợp nếu cần.
+*/
+func isHXBoosted(r *http.Request) bool {
+	return r.Header.Get("HX-Boosted") == "true"
+}
+
+/*
+writeHXTrigger gắn header "HX-Trigger" với danh sách tên event, để htmx kích hoạt các
+client-side event sau khi swap xong response. Dùng khi event không cần mang theo dữ liệu
+- xem writeHXTriggerJSON cho trường hợp cần truyền payload.
+*/
+func writeHXTrigger(w http.ResponseWriter, events ...string) {
+	for _, event := range events {
+		w.Header().Add("HX-Trigger", event)
+	}
+}
+
+/*
+writeHXTriggerJSON gắn header "HX-Trigger" dưới dạng một object JSON tên-event -> payload,
+đúng định dạng htmx mong đợi khi event cần mang theo dữ liệu (ví dụ
+{"showMessage": {"level": "info", "text": "Saved"}}).
+*/
+func writeHXTriggerJSON(w http.ResponseWriter, payload map[string]interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("HX-Trigger", string(data))
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/justinas/nosurf"
+)
+
+/*
+noSurf bọc handler kế tiếp bằng bảo vệ CSRF của nosurf. Cookie token được đánh dấu
+Secure khi app không chạy ở môi trường "development" (local dev thường không chạy qua
+HTTPS), và Path được giới hạn ở "/" để cookie áp dụng cho toàn site.
+*/
+func (app *application) noSurf(next http.Handler) http.Handler {
+	csrfHandler := nosurf.New(next)
+
+	csrfHandler.SetBaseCookie(http.Cookie{
+		HttpOnly: true,
+		Path:     "/",
+		Secure:   app.config.env != "development",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return csrfHandler
+}
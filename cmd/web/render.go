@@ -1,17 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"embed"
 	"fmt"
+	htemplate "html/template"
+	"io"
 	"net/http"
+	"path"
 	"strings"
-	"text/template"
+	"sync"
+	ttemplate "text/template"
+
+	"github.com/justinas/nosurf"
 )
 
 /*
 templateData chứa dữ liệu được truyền vào các template HTML.
 Nó bao gồm các dữ liệu chung như CSRF token, flash message, trạng thái xác thực người dùng,
 cũng như dữ liệu cụ thể cho từng trang thông qua các map khác nhau.
+
+PushURL và HXBlockName phục vụ render cho HTMX: PushURL, nếu khác rỗng, được renderTemplate
+đẩy vào header "HX-Push-Url" để cập nhật URL trên thanh địa chỉ mà không điều hướng cả
+trang. HXBlockName cho phép handler chỉ định tên block được render khi request đến từ
+HTMX (xem renderTemplate) thay vì mặc định "content".
 */
 type templateData struct {
 	StringMap       map[string]string
@@ -25,13 +37,149 @@ type templateData struct {
 	IsAuthenticated int
 	API             string
 	CSSVersion      string
+	PushURL         string
+	HXBlockName     string
+}
+
+/*
+OutputFormat xác định template nên được parse/execute bằng html/template (có escaping
+theo ngữ cảnh HTML) hay text/template (dùng cho JSON/CSV/XML/plain-text, nơi escaping
+HTML sẽ làm hỏng output), tương tự cờ IsPlainText của Hugo.
+*/
+type OutputFormat int
+
+const (
+	FormatHTML OutputFormat = iota
+	FormatJSON
+	FormatCSV
+	FormatXML
+	FormatTXT
+)
+
+/*
+IsPlainText báo cho renderTemplate biết format này có nên dùng engine text/template
+(không escaping HTML) thay vì html/template hay không.
+*/
+func (f OutputFormat) IsPlainText() bool {
+	return f != FormatHTML
+}
+
+/*
+ContentType trả về giá trị header Content-Type tương ứng với format, để renderTemplate
+set đúng header trước khi ghi body.
+*/
+func (f OutputFormat) ContentType() string {
+	switch f {
+	case FormatJSON:
+		return "application/json"
+	case FormatCSV:
+		return "text/csv"
+	case FormatXML:
+		return "application/xml"
+	case FormatTXT:
+		return "text/plain"
+	default:
+		return "text/html; charset=utf-8"
+	}
 }
 
 /*
-functions là một map chứa các hàm tùy chỉnh có thể được sử dụng trong template.
-Hiện tại nó đang trống nhưng có thể được mở rộng với các hàm trợ giúp.
+fileSuffix trả về phần hậu tố được chèn vào tên file trước ".tmpl", ví dụ
+"home.page.json.tmpl" cho FormatJSON. FormatHTML không có hậu tố.
 */
-var functions = template.FuncMap{}
+func (f OutputFormat) fileSuffix() string {
+	switch f {
+	case FormatJSON:
+		return ".json"
+	case FormatCSV:
+		return ".csv"
+	case FormatXML:
+		return ".xml"
+	case FormatTXT:
+		return ".txt"
+	default:
+		return ""
+	}
+}
+
+/*
+formatBySuffix ánh xạ hậu tố tên file (phần đứng trước ".tmpl" cuối cùng, ví dụ "json"
+trong "home.page.json.tmpl") sang OutputFormat tương ứng. Tên không nhận diện được
+(hoặc không có hậu tố) sẽ rơi về FormatHTML - đây là lựa chọn an toàn vì html/template
+luôn escape đúng ngữ cảnh, trong khi chọn nhầm text/template cho HTML sẽ mở ra XSS.
+*/
+var formatBySuffix = map[string]OutputFormat{
+	"json": FormatJSON,
+	"csv":  FormatCSV,
+	"xml":  FormatXML,
+	"txt":  FormatTXT,
+}
+
+/*
+detectOutputFormat tách tên page (ví dụ "home.json" trong lời gọi renderTemplate) thành
+tên cơ sở ("home") và OutputFormat suy ra từ hậu tố. Cho phép handler gọi
+app.renderTemplate(w, r, "home.json", td) để nhận về JSON thay vì phải truyền format
+tường minh.
+*/
+func detectOutputFormat(page string) (base string, format OutputFormat) {
+	idx := strings.LastIndex(page, ".")
+	if idx == -1 {
+		return page, FormatHTML
+	}
+
+	if f, ok := formatBySuffix[page[idx+1:]]; ok {
+		return page[:idx], f
+	}
+
+	return page, FormatHTML
+}
+
+/*
+renderableTemplate là phần giao nhau giữa *html/template.Template và
+*text/template.Template mà renderTemplate thực sự cần: thực thi toàn bộ template
+(Execute) hoặc một sub-template theo tên (ExecuteTemplate, dùng bởi CallTemplate) ra
+một io.Writer. Nhờ interface này, TemplateRenderer có thể cache và execute cả hai họ
+template mà không cần nhánh rẽ kiểu ở nơi gọi.
+*/
+type renderableTemplate interface {
+	Execute(wr io.Writer, data interface{}) error
+	ExecuteTemplate(wr io.Writer, name string, data interface{}) error
+}
+
+/*
+functions là map các hàm tùy chỉnh dùng chung cho cả hai engine template. Dùng
+map[string]interface{} thuần (thay vì htemplate.FuncMap hay ttemplate.FuncMap) vì hai
+kiểu FuncMap đó có cùng underlying type nên có thể convert tường minh sang kiểu phù hợp
+tùy theo engine đang parse (xem TemplateRenderer.Parse).
+
+"CallTemplate" đăng ký ở đây chỉ là placeholder để Parse không báo lỗi "function
+CallTemplate not defined" - TemplateRenderer.Parse override nó bằng closure thật sự
+(capture chính template vừa parse) ngay sau khi parse xong, trước khi template được
+Execute lần nào.
+*/
+var functions = map[string]interface{}{
+	"CallTemplate": func(name string, data interface{}) (htemplate.HTML, error) {
+		return "", fmt.Errorf("CallTemplate: %q invoked before template was parsed", name)
+	},
+}
+
+/*
+callTemplateFunc dựng hàm thật sự đứng sau "CallTemplate" trong FuncMap: nó
+capture chính template t vừa được parse, execute sub-template tên "name" (tên này có
+thể đến từ dữ liệu, chứ không bắt buộc là literal như cú pháp {{template ...}} gốc của
+Go) vào một buffer, rồi trả về template.HTML để engine HTML không escape lại output đã
+tự escape từ lần Execute bên trong - nếu trả về string thường, output của sub-template
+sẽ bị escape hai lần.
+*/
+func callTemplateFunc(t renderableTemplate) func(name string, data interface{}) (htemplate.HTML, error) {
+	return func(name string, data interface{}) (htemplate.HTML, error) {
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", err
+		}
+		return htemplate.HTML(buf.String()), nil
+	}
+}
 
 /*
 templateFS chứa hệ thống tệp được nhúng cho các template HTML.
@@ -39,28 +187,188 @@ Chỉ thị `//go:embed templates` nhúng nội dung của thư mục "templates
 vào trong file thực thi đã biên dịch, cho phép truy cập template
 mà không cần dựa vào hệ thống tệp bên ngoài.
 */
-// go:embed templates
+//go:embed templates
 var templateFS embed.FS
 
 /*
-addDefaultData thêm các dữ liệu mặc định vào templateData.
-Các dữ liệu này thường là chung cho tất cả các template, ví dụ như URL API,
-phiên bản CSS, hoặc thông tin dựa trên session.
+templateBuild mô tả mọi thứ cần thiết để dựng lại một template nhóm (group) cụ thể:
+base layout, danh sách file cần parse, và format quyết định engine nào (html/template
+hay text/template) sẽ parse chúng.
+
+group phân biệt các page trùng tên key nhưng thuộc ngữ cảnh khác nhau (ví dụ: trang
+"index" của khu vực "admin" so với khu vực "members", hoặc cùng một page được render đầy
+đủ so với render thành fragment cho HTMX), nhờ đó khóa cache không bị đụng độ.
+
+base để rỗng nghĩa là không parse base.layout.tmpl - dùng cho request HTMX, nơi chỉ
+một block bên trong page (xem renderTemplate) được cần tới, không phải toàn bộ layout.
+*/
+type templateBuild struct {
+	group  string
+	key    string
+	base   string
+	files  []string
+	format OutputFormat
+}
+
+/*
+TemplateRenderer đóng gói toàn bộ vòng đời parse/cache của template, lấy cảm hứng từ
+TemplateRenderer trong saasitone. application embed trực tiếp giá trị này nên các
+handler vẫn gọi app.parseTemplate/app.renderTemplate như trước.
+
+htmlCache và textCache tách riêng vì một page HTML và một page text/JSON có thể trùng
+key nhưng không bao giờ được lẫn template của nhau (một partial HTML không thể include
+vào giữa một template text/template và ngược lại). Cả hai dùng sync.Map thay vì map
+thường vì nhiều request có thể parse lại template đồng thời trong môi trường
+development (hot-reload), và map thường không an toàn khi đọc/ghi đồng thời.
+*/
+type TemplateRenderer struct {
+	htmlCache sync.Map
+	textCache sync.Map
+}
+
+/*
+cacheKey tạo khóa cache namespaced theo group để hai page cùng key nhưng khác group
+không ghi đè lẫn nhau trong cache/builds.
+*/
+func cacheKey(group, key string) string {
+	return fmt.Sprintf("%s:%s", group, key)
+}
+
+/*
+cacheFor trả về sync.Map tương ứng với format: textCache cho mọi plain-text format,
+htmlCache cho FormatHTML. Tập trung logic chọn cache ở một chỗ để Parse/Load không bị
+lệch nhau khi thêm format mới.
+*/
+func (tr *TemplateRenderer) cacheFor(format OutputFormat) *sync.Map {
+	if format.IsPlainText() {
+		return &tr.textCache
+	}
+	return &tr.htmlCache
+}
+
+/*
+Load trả về template đã parse cho (group, key, format) nếu đã có trong cache, cùng một
+bool cho biết có tìm thấy hay không.
+*/
+func (tr *TemplateRenderer) Load(group, key string, format OutputFormat) (renderableTemplate, bool) {
+	v, ok := tr.cacheFor(format).Load(cacheKey(group, key))
+	if !ok {
+		return nil, false
+	}
+	return v.(renderableTemplate), true
+}
+
+/*
+Parse dựng một renderableTemplate từ templateBuild: bắt đầu từ base layout, thêm các
+file được liệt kê trong build.files. build.format quyết định dùng html/template (escape
+HTML) hay text/template (không escape, dùng cho JSON/CSV/XML/TXT). Kết quả được lưu vào
+cache dưới khóa namespaced theo group, để Load sau này có thể tái sử dụng.
+*/
+func (tr *TemplateRenderer) Parse(build templateBuild) (renderableTemplate, error) {
+	files := make([]string, 0, len(build.files)+1)
+
+	if build.base != "" {
+		files = append(files, build.base)
+	}
+	files = append(files, build.files...)
+
+	var t renderableTemplate
+	var err error
+
+	// Tên của root template PHẢI là base name của file (ví dụ "home.page.tmpl"), không
+	// phải build.key đầy đủ (ví dụ "templates/home.page.tmpl") - ParseFS luôn đặt tên mỗi
+	// template đã parse theo path.Base của nó, nên root template chỉ "nhận" nội dung của
+	// page khi hai tên khớp nhau. Lệch tên ở đây khiến Execute báo "is an incomplete or
+	// empty template" dù parse không hề lỗi.
+	rootName := path.Base(build.key)
+
+	if build.format.IsPlainText() {
+		var tt *ttemplate.Template
+		tt, err = ttemplate.New(rootName).Funcs(ttemplate.FuncMap(functions)).ParseFS(templateFS, files...)
+		if err == nil {
+			tt.Funcs(ttemplate.FuncMap{"CallTemplate": callTemplateFunc(tt)})
+		}
+		t = tt
+	} else {
+		var ht *htemplate.Template
+		ht, err = htemplate.New(rootName).Funcs(htemplate.FuncMap(functions)).ParseFS(templateFS, files...)
+		if err == nil {
+			ht.Funcs(htemplate.FuncMap{"CallTemplate": callTemplateFunc(ht)})
+		}
+		t = ht
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tr.cacheFor(build.format).Store(cacheKey(build.group, build.key), t)
+
+	return t, nil
+}
+
+/*
+addDefaultData thêm các dữ liệu mặc định vào templateData: CSRF token (từ nosurf),
+các flash/warning/error message một lần (PopString xóa chúng khỏi session ngay sau khi
+đọc, nên chúng chỉ hiển thị đúng một lần cho request tiếp theo sau khi được set),
+trạng thái đăng nhập (dựa trên key "authenticatedUserID" trong session), và API/CSSVersion
+lấy từ app.config.
 */
 func (app *application) addDefaultData(td *templateData, r *http.Request) *templateData {
+	td.Flash = app.session.PopString(r.Context(), "flash")
+	td.Warning = app.session.PopString(r.Context(), "warning")
+	td.Error = app.session.PopString(r.Context(), "error")
+
+	if app.session.Exists(r.Context(), "authenticatedUserID") {
+		td.IsAuthenticated = 1
+	}
+
+	td.CSRFToken = nosurf.Token(r)
+	td.API = app.config.api
+	td.CSSVersion = app.config.cssVersion
+
 	return td
 }
 
 /*
-renderTemplate render một trang HTML cụ thể cùng với các partials được chỉ định (nếu có).
-Nó xử lý việc cache template trong môi trường production để cải thiện hiệu suất.
+pageCacheKey dựng cache key cho một CachedPage: namespaced theo group+key (cùng quy ước
+với cacheKey của TemplateRenderer), rồi ghép thêm URL đầy đủ của request, user id, và cờ
+hx để hai user khác nhau (hoặc hai URL khác nhau dùng chung Key, ví dụ phân trang), hay
+một request HTMX fragment so với request tải cả trang, không lẫn cache của nhau.
+CSRFToken cố tình không nằm trong key - nó được set lại trên mỗi request bởi nosurf nên
+sẽ không ổn định giữa các request giống hệt nhau. renderTemplate tự chặn việc cache một
+response có chứa CSRFToken của chính request đó (xem guard ngay trước app.cache.Set),
+nên lỡ có trang nào render {{.CSRFToken}} thì response đó chỉ là bị bỏ qua cache, chứ
+không bị replay token cũ cho visitor khác.
+*/
+func pageCacheKey(cache *CachedPage, r *http.Request, userID int, hx bool) string {
+	return fmt.Sprintf("%s:%s:%d:%t", groupKey(cache.Group, cache.Key), r.URL.String(), userID, hx)
+}
+
+/*
+renderTemplate render một trang cụ thể cùng với các partials được chỉ định (nếu có).
+page có thể mang hậu tố format (ví dụ "home.json" để render "home.page.json.tmpl" bằng
+text/template) - xem detectOutputFormat. Với page không có hậu tố nhận diện được,
+format mặc định là HTML và partials được parse bằng cùng engine HTML (một template HTML
+không thể include một partial text/template và ngược lại). Hàm xử lý việc cache
+template trong môi trường production để cải thiện hiệu suất.
+
+Nếu cache khác nil, renderTemplate còn tra/ghi page-level cache qua app.cache (xem
+CachedPage): cache bị bỏ qua hoàn toàn khi app.config.env != "production", vì development
+luôn cần thấy thay đổi ngay lập tức.
+
+Khi request mang header "HX-Request: true" (hoặc "HX-Boosted: true"), renderTemplate
+chuyển sang chế độ fragment cho HTMX: bỏ qua base.layout.tmpl và chỉ ExecuteTemplate
+block "content" (hoặc td.HXBlockName nếu được set) của page, trả về một mẩu HTML vừa đủ
+để HTMX swap vào DOM thay vì cả trang. td.PushURL, nếu khác rỗng, được đẩy vào header
+"HX-Push-Url" để HTMX cập nhật URL trên thanh địa chỉ.
 
 Tham số:
 
-	w: http.ResponseWriter để ghi HTML đã render ra.
+	w: http.ResponseWriter để ghi nội dung đã render ra.
 	r: *http.Request cho request hiện tại, được sử dụng cho context (ví dụ: bởi addDefaultData).
-	page: Tên cơ sở của template trang cần render (ví dụ: "home" cho "home.page.tmpl").
+	page: Tên cơ sở của template trang cần render, có thể kèm hậu tố format (ví dụ "home" hoặc "home.json").
 	td: Con trỏ tới templateData chứa dữ liệu sẽ được truyền vào template. Nếu nil, một templateData mới sẽ được khởi tạo.
+	cache: Nếu khác nil, opt-in trang này vào page-level response cache. nil nghĩa là không cache.
 	partials: Một slice variadic các chuỗi, mỗi chuỗi là tên cơ sở của một partial template
 			sẽ được bao gồm (ví dụ: "nav", "footer").
 
@@ -68,29 +376,66 @@ Trả về:
 
 	Một error nếu việc parse hoặc thực thi template thất bại, ngược lại trả về nil.
 */
-func (app *application) renderTemplate(w http.ResponseWriter, r *http.Request, page string, td *templateData, partials ...string) error {
-	var t *template.Template // Khai báo biến t để lưu trữ template đã được parse hoặc lấy từ cache.
+func (app *application) renderTemplate(w http.ResponseWriter, r *http.Request, page string, td *templateData, cache *CachedPage, partials ...string) error {
+	var t renderableTemplate // Khai báo biến t để lưu trữ template đã được parse hoặc lấy từ cache.
 	var err error            // Khai báo biến err để bắt lỗi.
 
+	base, format := detectOutputFormat(page)
+	hx := isHXRequest(r) || isHXBoosted(r)
+
+	cacheEnabled := cache != nil && app.config.env == "production"
+	var pageKey string
+
+	if cacheEnabled {
+		pageKey = pageCacheKey(cache, r, app.session.GetInt(r.Context(), "authenticatedUserID"), hx)
+
+		if data, contentType, ok := app.cache.Get(pageKey); ok {
+			if app.cacheMetrics != nil {
+				app.cacheMetrics.Hit(pageKey)
+			}
+			// td.PushURL phải được đẩy vào header ở cả nhánh hit lẫn nhánh miss - trước
+			// đây nhánh hit return sớm mà chưa từng nhìn tới td, nên HX-Push-Url chỉ xuất
+			// hiện ở request đầu tiên (miss) rồi biến mất ở mọi request sau (hit).
+			if td != nil && td.PushURL != "" {
+				w.Header().Set("HX-Push-Url", td.PushURL)
+			}
+			w.Header().Set("Content-Type", contentType)
+			_, err := w.Write(data)
+			return err
+		}
+
+		if app.cacheMetrics != nil {
+			app.cacheMetrics.Miss(pageKey)
+		}
+	}
+
 	// Tạo tên đầy đủ của file template trang cần render.
 	// Ví dụ: nếu page là "home", templateToRender sẽ là "templates/home.page.tmpl".
-	templateToRender := fmt.Sprintf("templates/%s.page.tmpl", page)
+	// Nếu page là "home.json", templateToRender sẽ là "templates/home.page.json.tmpl".
+	templateToRender := fmt.Sprintf("templates/%s.page%s.tmpl", base, format.fileSuffix())
+
+	// Group "page" cho request tải cả trang, "page:hx" cho fragment HTMX - cùng file
+	// nguồn nhưng được parse khác nhau (có/không có base.layout.tmpl) nên không thể
+	// dùng chung một entry cache.
+	group := "page"
+	if hx {
+		group = "page:hx"
+	}
 
 	// Kiểm tra xem template đã có trong cache hay chưa.
-	// templateInMap sẽ là true nếu templateToRender tồn tại trong app.templateCache.
-	_, templateInMap := app.templateCache[templateToRender]
+	t, templateInMap := app.Load(group, templateToRender, format)
 
 	// Logic xử lý cache template:
 	// Nếu ứng dụng đang chạy ở môi trường "production" VÀ template đã có trong cache,
 	// thì sử dụng template từ cache.
 	if app.config.env == "production" && templateInMap {
-		t = app.templateCache[templateToRender]
+		// t đã được gán ở trên.
 	} else {
 		// Ngược lại (môi trường không phải "production" HOẶC template chưa có trong cache),
 		// tiến hành parse lại template.
 		// Điều này hữu ích trong môi trường "development" để thấy thay đổi ngay lập tức
 		// mà không cần khởi động lại ứng dụng.
-		t, err = app.parseTemplate(partials, page, templateToRender)
+		t, err = app.parseTemplate(partials, base, templateToRender, format, hx)
 
 		// Nếu có lỗi trong quá trình parse template, ghi log và trả về lỗi.
 		if err != nil {
@@ -111,9 +456,19 @@ func (app *application) renderTemplate(w http.ResponseWriter, r *http.Request, p
 	// Hàm này có thể tùy chỉnh td trước khi nó được sử dụng để render template.
 	td = app.addDefaultData(td, r)
 
-	// Thực thi template (render HTML) với dữ liệu td và ghi kết quả vào http.ResponseWriter (w).
-	// Template t đã được parse (hoặc lấy từ cache) sẽ được "điền" dữ liệu từ td.
-	err = t.Execute(w, td)
+	// Nếu đang cache trang này, render vào buffer trước để có thể lưu []byte vào
+	// app.cache trước khi ghi ra w - tránh vừa ghi response vừa phải rollback khi lỗi.
+	var buf bytes.Buffer
+	if hx {
+		// Request HTMX chỉ cần một block bên trong page, không phải toàn bộ layout.
+		blockName := td.HXBlockName
+		if blockName == "" {
+			blockName = "content"
+		}
+		err = t.ExecuteTemplate(&buf, blockName, td)
+	} else {
+		err = t.Execute(&buf, td)
+	}
 
 	// Nếu có lỗi trong quá trình thực thi template (ví dụ: lỗi cú pháp trong template,
 	// hoặc lỗi khi ghi vào ResponseWriter), ghi log và trả về lỗi.
@@ -122,80 +477,91 @@ func (app *application) renderTemplate(w http.ResponseWriter, r *http.Request, p
 		return err                // Trả về lỗi.
 	}
 
+	contentType := format.ContentType()
+
+	if cacheEnabled {
+		// Một response mang theo CSRFToken của chính request này không được phép vào
+		// cache - nó sẽ bị replay nguyên văn cho mọi visitor/session sau đó, vô hiệu hóa
+		// bảo vệ CSRF cho tất cả trừ người đầu tiên. Đây là guard runtime thực sự, không
+		// chỉ dựa vào quy ước "đừng render .CSRFToken trong trang cache" ở doc comment.
+		if td.CSRFToken != "" && bytes.Contains(buf.Bytes(), []byte(td.CSRFToken)) {
+			app.errorLog.Printf("renderTemplate: refusing to cache %q - response embeds a per-request CSRF token", pageKey)
+		} else {
+			app.cache.Set(pageKey, buf.Bytes(), contentType, cache.Expiration, cache.Tags...)
+		}
+	}
+
+	if td.PushURL != "" {
+		w.Header().Set("HX-Push-Url", td.PushURL)
+	}
+
+	// Set Content-Type theo format trước khi ghi body, để client (và HTMX/fetch) biết
+	// cách diễn giải response.
+	w.Header().Set("Content-Type", contentType)
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		app.errorLog.Println(err)
+		return err
+	}
+
 	// Nếu không có lỗi nào xảy ra trong suốt quá trình, trả về nil để báo hiệu thành công.
 	return nil
 
 }
 
 /*
-parseTemplate parse một tập hợp các tệp template (layout cơ sở, trang, và các partials tùy chọn)
-từ hệ thống tệp được nhúng (templateFS).
-Nó xây dựng một template mới, liên kết nó với các hàm tùy chỉnh, và parse các tệp được chỉ định.
-Template đã được parse sau đó được lưu trữ trong cache template của ứng dụng.
+parseTemplate parse một tập hợp các tệp template (layout cơ sở, trang, và các partials
+tùy chọn) từ hệ thống tệp được nhúng (templateFS), thông qua TemplateRenderer nhúng
+trong application. build.group là "page" cho lối gọi renderTemplate cũ (partials tường
+minh), hoặc "page:hx" khi hx là true; các chunk sau sẽ bổ sung thêm những group khác
+(ví dụ group theo khu vực admin/members). format quyết định partials được parse bằng
+engine nào - chúng luôn kế thừa "kind" (HTML hay plain-text) của trang chứa chúng. Khi
+hx là true, base.layout.tmpl không được đưa vào build vì renderTemplate chỉ cần
+ExecuteTemplate một block con của page, không phải toàn bộ layout.
 
 Tham số:
 
 	partials: Một slice các chuỗi, mỗi chuỗi là tên cơ sở của một partial template
 			(ví dụ: "nav" cho "templates/nav.partial.tmpl").
-	page: Tên cơ sở của template trang chính (ví dụ: "home" cho "templates/home.page.tmpl").
-	templateToRender: Đường dẫn đầy đủ của tệp template trang, được sử dụng làm khóa cho cache template
-			(ví dụ: "templates/home.page.tmpl").
+	page: Tên cơ sở của template trang chính, không kèm hậu tố format (ví dụ "home").
+	templateToRender: Đường dẫn đầy đủ của tệp template trang, được sử dụng làm khóa cache
+			(ví dụ: "templates/home.page.tmpl" hoặc "templates/home.page.json.tmpl").
+	format: OutputFormat của trang, xem detectOutputFormat.
+	hx: true nếu request hiện tại đến từ HTMX (xem isHXRequest/isHXBoosted).
 
 Trả về:
 
-	Một con trỏ tới *template.Template đã được parse và một error nếu việc parse thất bại, ngược lại trả về nil.
-*/
-func (app *application) parseTemplate(partials []string, page, templateToRender string) (*template.Template, error) {
-	var t *template.Template // Khai báo biến t để lưu trữ template đã được parse.
-	var err error            // Khai báo biến err để bắt lỗi trong quá trình parse.
-
-	// build partials: Chuẩn bị đường dẫn đầy đủ cho các file partial template.
-	// Lưu ý: Đường dẫn này được xây dựng dựa trên giả định các file partial nằm trong thư mục "templates".
-	if len(partials) > 0 { // Kiểm tra xem có partial template nào được truyền vào không.
-		for i, x := range partials { // Duyệt qua danh sách tên các partial.
-			// Cập nhật phần tử trong slice 'partials' thành đường dẫn đầy đủ.
-			// Ví dụ: nếu x là "nav", partials[i] sẽ trở thành "templates/nav.partial.tmpl".
-			// Quan trọng: Với `embed.FS` và `ParseFS`, đường dẫn nên là tương đối so với thư mục gốc đã embed (ví dụ: "nav.partial.tmpl" nếu "templates" là thư mục gốc).
-			// Tuy nhiên, cách sử dụng `strings.Join` bên dưới có thể không hoạt động như mong đợi với `ParseFS` cho nhiều partials.
-			partials[i] = fmt.Sprintf("templates/%s.partial.tmpl", x)
-		}
+	Một renderableTemplate đã được parse và một error nếu việc parse thất bại, ngược lại trả về nil.
+*/
+func (app *application) parseTemplate(partials []string, page, templateToRender string, format OutputFormat, hx bool) (renderableTemplate, error) {
+	// build partials: Chuẩn bị đường dẫn đầy đủ cho các file partial template, mỗi partial
+	// là một phần tử riêng trong slice files để ParseFS nhận đúng các đối số variadic của nó
+	// (trước đây bug nằm ở chỗ strings.Join các partial thành một chuỗi duy nhất).
+	files := make([]string, 0, len(partials))
+	for _, x := range partials {
+		files = append(files, fmt.Sprintf("templates/%s.partial.tmpl", x))
 	}
 
-	if len(partials) > 0 { // Nếu có partial templates được chỉ định.
-		// Bắt đầu một template mới, đặt tên theo file page (ví dụ: "home.page.tmpl").
-		// Tên này quan trọng vì nó là tên mà các template khác (như base.layout.tmpl) sẽ dùng để {{define "page_name.page.tmpl"}}
-		// Thêm các hàm tùy chỉnh (functions) vào template để có thể sử dụng trong các file .tmpl.
-		// Parse các file từ templateFS (hệ thống file nhúng):
-		// 1. "base.layout.tmpl": File layout cơ sở, thường chứa cấu trúc HTML chung.
-		// 2. strings.Join(partials, ","): Nối tất cả các đường dẫn partial đã chuẩn bị ở trên thành một chuỗi duy nhất,
-		//    phân tách bằng dấu phẩy.
-		//    CẢNH BÁO: `ParseFS` mong đợi các tên file là các đối số chuỗi riêng biệt (variadic ...string).
-		//    Việc truyền một chuỗi duy nhất như thế này có thể sẽ chỉ parse file đầu tiên hoặc gây lỗi,
-		//    chứ không parse tất cả các partials như ý định.
-		// 3. templateToRender: File page chính cần render (ví dụ: "templates/home.page.tmpl").
-		t, err = template.
-			New(fmt.Sprintf("%s.page.tmpl", page)).                                                // Đặt tên cho template chính, thường là tên file của page.
-			Funcs(functions).                                                                      // Gắn các hàm helper vào template.
-			ParseFS(templateFS, "base.layout.tmpl", strings.Join(partials, ","), templateToRender) // Parse các file template.
-	} else { // Nếu không có partial templates nào được chỉ định.
-		// Tương tự như trên, nhưng chỉ parse file layout cơ sở và file page chính.
-		// Không có partials nào được bao gồm.
-		t, err = template.
-			New(fmt.Sprintf("%s.page.tmpl", page)).                   // Đặt tên cho template chính.
-			Funcs(functions).                                         // Gắn các hàm helper.
-			ParseFS(templateFS, "base.layout.tmpl", templateToRender) // Parse layout và page.
-	}
-
-	// Kiểm tra lỗi sau khi thực hiện ParseFS.
-	if err != nil {
-		app.errorLog.Println(err) // Ghi log lỗi nếu có lỗi xảy ra trong quá trình parse.
-		return nil, err           // Trả về nil cho template và trả về lỗi đã xảy ra.
+	group := "page"
+	baseLayout := "templates/base.layout.tmpl"
+	if hx {
+		group = "page:hx"
+		baseLayout = ""
 	}
 
-	// Nếu parse thành công, lưu template đã parse vào cache của ứng dụng.
-	// Khóa cache là 'templateToRender' (ví dụ: "templates/home.page.tmpl"),
-	// giá trị là con trỏ tới template đã parse (t).
-	app.templateCache[templateToRender] = t
+	build := templateBuild{
+		group:  group,
+		key:    templateToRender,
+		base:   baseLayout,
+		files:  append(files, templateToRender),
+		format: format,
+	}
+
+	t, err := app.Parse(build)
+	if err != nil {
+		app.errorLog.Println(err) // Ghi lại chi tiết lỗi nếu có lỗi xảy ra trong quá trình parse.
+		return nil, err
+	}
 
-	return t, nil // Trả về template đã parse thành công và không có lỗi (err là nil).
+	return t, nil
 }